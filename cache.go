@@ -2,31 +2,64 @@ package cache
 
 import (
 	"bytes"
+	"context"
 	"crypto/md5"
+	"crypto/sha256"
 	"encoding/gob"
 	"encoding/hex"
 	"errors"
 	"io"
 	"net/http"
+	"net/http/httptest"
 	"strings"
 	"strconv"
 	"time"
 
+	"github.com/araddon/dateparse"
 	"github.com/gin-gonic/gin"
+	"golang.org/x/sync/singleflight"
 )
 
 const KEY_PREFIX = "gin:cache:"
 
+// tagsContextKey is the gin.Context key Tag stores pending cache tags under.
+const tagsContextKey = "gin-cache:tags"
+
+// Tag annotates the in-flight response with cache tags. If the response gets
+// cached and the configured Store implements TaggedStore, the entry is
+// recorded under every tag so a later InvalidateTag call evicts it in bulk
+// alongside any other response sharing that tag, e.g.:
+//
+//	cache.Tag(c, "user:42", "posts")
+func Tag(c *gin.Context, tags ...string) {
+	existing, _ := c.Get(tagsContextKey)
+	all, _ := existing.([]string)
+	c.Set(tagsContextKey, append(all, tags...))
+}
+
 var (
 	ErrNotFound      = errors.New("not found")
 	ErrAlreadyExists = errors.New("already exists")
 )
 
 type Cached struct {
-	Status   int
-	Body     []byte
-	Header   http.Header
-	ExpireAt time.Time
+	Status    int
+	Body      []byte
+	Header    http.Header
+	ExpireAt  time.Time
+	CreatedAt time.Time
+	Hash      string
+	// StaleAt marks when the entry becomes eligible for stale-while-revalidate
+	// serving. Zero means the entry is never served stale.
+	StaleAt time.Time
+	// Encoding is the Content-Encoding Body is compressed with ("gzip" or
+	// "br"), or empty if Body is stored uncompressed.
+	Encoding string
+	// Tags records the cache tags this entry was stored under, so a
+	// stale-while-revalidate refresh can re-tag the replacement entry without
+	// re-resolving Tag() calls made against a background request it can't
+	// observe.
+	Tags []string
 }
 
 type Store interface {
@@ -42,25 +75,89 @@ type Options struct {
 	Expire        time.Duration
 	Headers       []string
 	DoNotUseAbort bool
+
+	// DoNotCacheCookies lists cookie names whose presence on the request
+	// bypasses caching entirely, e.g. session cookies.
+	DoNotCacheCookies []string
+
+	// StaleWhileRevalidate, if set, marks the final StaleWhileRevalidate of
+	// each entry's Expire lifetime as stale: during that window the entry is
+	// served immediately while a background request repopulates it. The
+	// window never starts before the entry was created and does not extend
+	// the lifetime past ExpireAt. Has no effect when Expire is zero. See
+	// RFC 5861.
+	StaleWhileRevalidate time.Duration
+
+	// Engine is the gin engine the middleware is attached to. It is required
+	// when StaleWhileRevalidate is set: a stale hit is served immediately and
+	// the entry is refreshed by replaying a clone of the request through
+	// Engine in the background, since a gin.Context.Copy() cannot drive
+	// execution of the original handler chain.
+	Engine *gin.Engine
+
+	// MinCompressSize is the smallest response body, in bytes, worth
+	// compressing before storing. Zero disables compression.
+	MinCompressSize int
+	// CompressionEncoding selects the algorithm used to compress stored
+	// bodies: "gzip" (the default) or "br" for brotli.
+	CompressionEncoding string
+
+	// MetricsHook, if set, is invoked periodically with a snapshot of the
+	// configured Store's runtime counters. Only stores that report
+	// RistrettoMetrics (e.g. those returned by NewRistrettoStore) support this.
+	MetricsHook func(RistrettoMetrics)
 }
 
 func (o *Options) init() {
 	if o.Headers == nil {
-		o.Headers = []string{
-			"User-Agent",
-			"Accept",
-			"Accept-Encoding",
-			"Accept-Language",
-			"Cookie",
-			"User-Agent",
-		}
+		o.Headers = defaultVaryHeaders()
+	}
+}
+
+func defaultVaryHeaders() []string {
+	return []string{
+		"User-Agent",
+		"Accept",
+		"Accept-Encoding",
+		"Accept-Language",
+		"Cookie",
+		"User-Agent",
 	}
 }
 
+// RouteCacheConfig overrides the global Options for a single route. It is
+// produced per request by the resolver function passed to NewWithConfig.
+type RouteCacheConfig struct {
+	// Expire overrides Options.Expire for this route.
+	Expire time.Duration
+	// Headers overrides which request headers vary the cache key for this
+	// route. A nil slice falls back to the default vary headers.
+	Headers []string
+	// Key, if non-empty, is used as the cache key, prefixed with KEY_PREFIX,
+	// instead of the default URL-plus-vary-headers hash.
+	Key string
+	// DoNotCacheCookies lists cookie names whose presence on the request
+	// bypasses caching entirely, e.g. session cookies.
+	DoNotCacheCookies []string
+	// StaleWhileRevalidate overrides Options.StaleWhileRevalidate for this
+	// route.
+	StaleWhileRevalidate time.Duration
+	// MinCompressSize overrides Options.MinCompressSize for this route.
+	MinCompressSize int
+	// CompressionEncoding overrides Options.CompressionEncoding for this
+	// route.
+	CompressionEncoding string
+}
+
 type Cache struct {
 	Store
 	options Options
 	expires map[string]time.Time
+	group   singleflight.Group
+	// engine is used by refreshStale to replay a request in the background.
+	// Nil when Options.Engine wasn't set, in which case refreshStale is a
+	// no-op and stale entries simply expire normally.
+	engine *gin.Engine
 }
 
 func (c *Cache) Get(key string) (*Cached, error) {
@@ -78,15 +175,27 @@ func (c *Cache) Get(key string) (*Cached, error) {
 	} else {
 		return nil, err
 	}
-
-	return nil, ErrNotFound
 }
 
 func (c *Cache) Set(key string, cch *Cached) error {
+	return c.SetWithTags(key, cch, nil)
+}
+
+// SetWithTags behaves like Set but, when the configured Store implements
+// TaggedStore and tags is non-empty, records the entry under those tags so
+// it can later be bulk-invalidated.
+func (c *Cache) SetWithTags(key string, cch *Cached, tags []string) error {
 	var b bytes.Buffer
 	enc := gob.NewEncoder(&b)
 
 	panicIf(enc.Encode(*cch))
+
+	if ts, ok := c.Store.(TaggedStore); ok && len(tags) > 0 {
+		return ts.SetWithTags(key, b.Bytes(), tags)
+	}
+	if cs, ok := c.Store.(CostedStore); ok {
+		return cs.SetWithCost(key, b.Bytes(), int64(len(cch.Body))+headerCost(cch.Header))
+	}
 	return c.Store.Set(key, b.Bytes())
 }
 
@@ -99,17 +208,48 @@ func (c *Cache) Update(key string, cch *Cached) error {
 	return c.Store.Update(key, b.Bytes())
 }
 
+// wrappedWriter buffers the handler's status, headers, and body instead of
+// streaming them straight through. The cache-populating request needs to add
+// Etag/Last-Modified once the body hash is known, and those can only reach
+// the client if nothing has been written to the real ResponseWriter yet;
+// flush sends the buffered response in one shot once those headers are set.
 type wrappedWriter struct {
 	gin.ResponseWriter
-	body bytes.Buffer
+	body   bytes.Buffer
+	status int
+}
+
+func (rw *wrappedWriter) WriteHeader(status int) {
+	rw.status = status
 }
 
+// WriteHeaderNow is called internally by gin (e.g. from Flush) to force the
+// status line out early; suppress it so nothing reaches the real
+// ResponseWriter before flush.
+func (rw *wrappedWriter) WriteHeaderNow() {}
+
 func (rw *wrappedWriter) Write(body []byte) (int, error) {
-	n, err := rw.ResponseWriter.Write(body)
-	if err == nil {
-		rw.body.Write(body)
+	return rw.body.Write(body)
+}
+
+func (rw *wrappedWriter) WriteString(s string) (int, error) {
+	return rw.body.WriteString(s)
+}
+
+func (rw *wrappedWriter) Flush() {}
+
+func (rw *wrappedWriter) Status() int {
+	if rw.status == 0 {
+		return http.StatusOK
 	}
-	return n, err
+	return rw.status
+}
+
+// flush writes the buffered status and body to the real ResponseWriter,
+// mirroring the header-then-body order serveCached uses for a HIT.
+func (rw *wrappedWriter) flush() {
+	rw.ResponseWriter.WriteHeader(rw.Status())
+	rw.ResponseWriter.Write(rw.body.Bytes())
 }
 
 func New(o ...Options) gin.HandlerFunc {
@@ -124,12 +264,50 @@ func New(o ...Options) gin.HandlerFunc {
 	}
 	opts.init()
 
-	cache := Cache{
+	cache := &Cache{
 		Store:   opts.Store,
 		options: opts,
 		expires: make(map[string]time.Time),
+		engine:  opts.Engine,
+	}
+
+	if opts.MetricsHook != nil {
+		if mp, ok := opts.Store.(metricsProvider); ok {
+			go reportMetrics(mp, opts.MetricsHook)
+		}
+	}
+
+	return newHandler(cache, func(c *gin.Context) *RouteCacheConfig {
+		return &RouteCacheConfig{
+			Expire:               cache.options.Expire,
+			Headers:              cache.options.Headers,
+			DoNotCacheCookies:    cache.options.DoNotCacheCookies,
+			StaleWhileRevalidate: cache.options.StaleWhileRevalidate,
+			MinCompressSize:      cache.options.MinCompressSize,
+			CompressionEncoding:  cache.options.CompressionEncoding,
+		}
+	})
+}
+
+// NewWithConfig returns caching middleware whose behavior is decided per
+// request by fn. Returning nil from fn skips caching entirely for that
+// request; otherwise the returned RouteCacheConfig overrides expiry, vary
+// headers, the cache key, and the do-not-cache cookie list. This lets a
+// single middleware instance mix cached and uncached routes. engine is used
+// to replay requests in the background for stale-while-revalidate refresh
+// (see Options.Engine); pass nil if no route resolved by fn ever sets
+// StaleWhileRevalidate.
+func NewWithConfig(engine *gin.Engine, fn func(*gin.Context) *RouteCacheConfig) gin.HandlerFunc {
+	cache := &Cache{
+		Store:   NewInMemory(),
+		expires: make(map[string]time.Time),
+		engine:  engine,
 	}
 
+	return newHandler(cache, fn)
+}
+
+func newHandler(cache *Cache, resolve func(*gin.Context) *RouteCacheConfig) gin.HandlerFunc {
 	return func(c *gin.Context) {
 
 		// only GET method available for caching
@@ -138,75 +316,277 @@ func New(o ...Options) gin.HandlerFunc {
 			return
 		}
 
-		tohash := c.Request.URL.RequestURI()
-		for _, k := range cache.options.Headers {
-			if v, ok := c.Request.Header[k]; ok {
-				tohash += k
-				tohash += strings.Join(v, "")
-			}
+		cfg := resolve(c)
+		if cfg == nil || bypassCache(c, cfg) {
+			c.Next()
+			return
 		}
 
-		key := KEY_PREFIX + md5String(tohash)
+		headers := cfg.Headers
+		if headers == nil {
+			headers = defaultVaryHeaders()
+		}
+
+		var key string
+		if cfg.Key != "" {
+			key = KEY_PREFIX + cfg.Key
+		} else {
+			tohash := c.Request.URL.RequestURI()
+			for _, k := range headers {
+				if v, ok := c.Request.Header[k]; ok {
+					tohash += k
+					tohash += strings.Join(v, "")
+				}
+			}
+			key = KEY_PREFIX + md5String(tohash)
+		}
 
 		// Get time from gin.Context
 		tn, _ := c.Get("TimeNow")
 		timeNow := tn.(time.Time)
 
 		if cch, _ := cache.Get(key); cch == nil {
-			// cache miss
-			writer := c.Writer
-			rw := wrappedWriter{ResponseWriter: c.Writer}
-			c.Writer = &rw
-			c.Writer.Header().Add("Etag", key)
-			c.Writer.Header().Add("X-Gin-Cache-Hit", "MISS")
-			c.Writer.Header().Add("Cache-Control", getCacheControl(getTimeDiff(timeNow, cache.options.Expire).Nanoseconds() / 1e9))
-			c.Next()
-			c.Writer = writer
-
-			cache.Set(key, &Cached{
-				Status: rw.Status(),
-				Body:   rw.body.Bytes(),
-				Header: http.Header(rw.Header()),
-				ExpireAt: func() time.Time {
-					if cache.options.Expire == 0 {
-						return time.Time{}
-					} else {
-						return getExpiresAtTime(timeNow, cache.options.Expire)
-					}
-				}(),
+			// cache miss: coalesce concurrent misses for the same key so only
+			// one goroutine runs the handler chain and populates the store.
+			// singleflight.Group.Do's own "shared" return is true whenever
+			// ANY duplicate joined while fn ran, including for the caller
+			// that actually ran fn (the leader) if a follower joined
+			// mid-flight — it does not mean "I was a follower". The leader
+			// already wrote its real response via rw.flush() inside fn, so
+			// it must not run serveCached again; track execution locally
+			// instead of trusting shared.
+			var executed bool
+			v, _, _ := cache.group.Do(key, func() (interface{}, error) {
+				executed = true
+				writer := c.Writer
+				rw := wrappedWriter{ResponseWriter: c.Writer}
+				c.Writer = &rw
+				c.Writer.Header().Add("X-Gin-Cache-Hit", "MISS")
+				c.Writer.Header().Add("Cache-Control", getCacheControl(getTimeDiff(timeNow, cfg.Expire).Nanoseconds()/1e9, int64(cfg.StaleWhileRevalidate.Seconds())))
+				c.Next()
+				c.Writer = writer
+
+				if !cacheable(rw.Status(), rw.Header()) {
+					rw.flush()
+					return nil, nil
+				}
+
+				hash := sha256String(rw.body.Bytes())
+				c.Writer.Header().Set("Etag", `"`+hash+`"`)
+				c.Writer.Header().Set("Last-Modified", timeNow.UTC().Format(http.TimeFormat))
+
+				newCch := newCached(rw.Status(), rw.body.Bytes(), http.Header(rw.Header()), hash, timeNow, cfg)
+				tags, _ := c.Get(tagsContextKey)
+				tagList, _ := tags.([]string)
+				newCch.Tags = tagList
+				cache.SetWithTags(key, newCch, tagList)
+				rw.flush()
+				return newCch, nil
 			})
 
-		} else {
-			// cache found
-			//start := time.Now()
-			c.Writer.WriteHeader(cch.Status)
-			for k, val := range cch.Header {
-				for _, v := range val {
-					c.Writer.Header().Add(k, v)
+			if !executed {
+				if v == nil {
+					// the leader's response wasn't cacheable (error status or
+					// Cache-Control: no-store); run the handler ourselves.
+					c.Next()
+					return
 				}
+				// the leader already produced this response; replay it here
+				// exactly like a HIT.
+				serveCached(c, cache, v.(*Cached), timeNow, cfg, "MISS")
 			}
-			c.Writer.Header().Set("X-Gin-Cache-Hit", "HIT")
-			c.Writer.Header().Set("Cache-Control", getCacheControl(getTimeDiff(timeNow, cache.options.Expire).Nanoseconds() / 1e9))
-			//c.Writer.Header().Set("Cache-Control", getCacheControl(getTimeDiffFromNow(cache.options.Expire).Nanoseconds() / 1e9))
 
-			//t := fmt.Sprintf("%f ms", timeNow.Sub(start).Seconds()*1000)
-			//c.Writer.Header().Add("X-Gin-Cache", t)
+		} else if !cch.StaleAt.IsZero() && timeNow.After(cch.StaleAt) {
+			// stale-while-revalidate: serve the stale entry immediately and
+			// refresh it in the background, coalesced through the same
+			// singleflight group as the miss path.
+			serveCached(c, cache, cch, timeNow, cfg, "STALE")
+			go refreshStale(cache, c, cfg, key, cch)
+
+		} else {
+			// cache found
+			serveCached(c, cache, cch, timeNow, cfg, "HIT")
+		}
+	}
+}
+
+// newCached builds a Cached entry, computing ExpireAt and, when
+// cfg.StaleWhileRevalidate is set, StaleAt.
+func newCached(status int, body []byte, header http.Header, hash string, timeNow time.Time, cfg *RouteCacheConfig) *Cached {
+	cch := &Cached{
+		Status:    status,
+		Body:      body,
+		Header:    header,
+		CreatedAt: timeNow,
+		Hash:      hash,
+	}
 
-			c.Writer.Write(cch.Body)
-			if !cache.options.DoNotUseAbort {
-				c.Abort()
+	if cfg.Expire != 0 {
+		cch.ExpireAt = getExpiresAtTime(timeNow, cfg.Expire)
+
+		if cfg.StaleWhileRevalidate > 0 {
+			staleAt := cch.ExpireAt.Add(-cfg.StaleWhileRevalidate)
+			if staleAt.Before(timeNow) {
+				staleAt = timeNow
 			}
+			cch.StaleAt = staleAt
+		}
+	}
+
+	if cfg.MinCompressSize > 0 && len(body) >= cfg.MinCompressSize && isCompressible(header.Get("Content-Type")) {
+		encoding := cfg.CompressionEncoding
+		if encoding == "" {
+			encoding = "gzip"
+		}
+		if compressed, err := compressBody(encoding, body); err == nil {
+			cch.Body = compressed
+			cch.Encoding = encoding
+		}
+	}
+
+	return cch
+}
+
+// refreshStale repopulates a stale-but-not-yet-expired entry in the
+// background by replaying a clone of the original request through
+// cache.engine. A gin.Context.Copy() can't do this: Copy resets the handler
+// chain and clears the underlying ResponseWriter specifically so the copy
+// can't drive execution, which made cpy.Next() a no-op and silently
+// overwrote the entry with an empty body on every refresh. The clone is
+// marked with the same ?cache=0 bypass bypassCache already understands, so
+// routing back through the full engine reaches the real handler instead of
+// this same middleware re-serving the still-stale entry. Concurrent
+// refreshes for the same key are coalesced through cache.group, just like
+// the miss path. If Options.Engine wasn't set, this is a no-op and the
+// entry simply expires normally instead of refreshing.
+func refreshStale(cache *Cache, c *gin.Context, cfg *RouteCacheConfig, key string, cch *Cached) {
+	if cache.engine == nil {
+		return
+	}
+
+	req := c.Request.Clone(context.Background())
+	q := req.URL.Query()
+	q.Set("cache", "0")
+	req.URL.RawQuery = q.Encode()
+
+	cache.group.Do(key, func() (interface{}, error) {
+		rec := httptest.NewRecorder()
+		cache.engine.ServeHTTP(rec, req)
+
+		if !cacheable(rec.Code, rec.Header()) {
+			return nil, nil
+		}
+
+		timeNow := time.Now()
+		body := rec.Body.Bytes()
+		hash := sha256String(body)
+		newCch := newCached(rec.Code, body, rec.Header(), hash, timeNow, cfg)
+		newCch.Tags = cch.Tags
+
+		cache.SetWithTags(key, newCch, cch.Tags)
+		return newCch, nil
+	})
+}
+
+// bypassCache reports whether the request should skip caching entirely: an
+// explicit ?cache=0/false query parameter, or a cookie named in
+// cfg.DoNotCacheCookies (e.g. a session cookie).
+func bypassCache(c *gin.Context, cfg *RouteCacheConfig) bool {
+	if v := c.Query("cache"); v == "0" || strings.EqualFold(v, "false") {
+		return true
+	}
+
+	for _, name := range cfg.DoNotCacheCookies {
+		if _, err := c.Request.Cookie(name); err == nil {
+			return true
+		}
+	}
+
+	return false
+}
+
+// cacheable reports whether a freshly produced response is allowed to be
+// stored: error responses and ones explicitly marked Cache-Control: no-store
+// must not poison the cache.
+func cacheable(status int, header http.Header) bool {
+	if status >= http.StatusBadRequest {
+		return false
+	}
+	if strings.Contains(header.Get("Cache-Control"), "no-store") {
+		return false
+	}
+	return true
+}
+
+// serveCached writes a previously stored response to c.Writer, honoring
+// conditional GET (If-None-Match / If-Modified-Since) and stamping the same
+// headers a fresh response would carry.
+func serveCached(c *gin.Context, cache *Cache, cch *Cached, timeNow time.Time, cfg *RouteCacheConfig, hitLabel string) {
+	cacheControl := getCacheControl(getTimeDiff(timeNow, cfg.Expire).Nanoseconds()/1e9, int64(cfg.StaleWhileRevalidate.Seconds()))
+
+	if isNotModified(c.Request, cch) {
+		c.Writer.Header().Set("Etag", `"`+cch.Hash+`"`)
+		c.Writer.Header().Set("Last-Modified", cch.CreatedAt.UTC().Format(http.TimeFormat))
+		c.Writer.Header().Set("X-Gin-Cache-Hit", hitLabel)
+		c.Writer.Header().Set("Cache-Control", cacheControl)
+		c.Writer.WriteHeader(http.StatusNotModified)
+		if !cache.options.DoNotUseAbort {
+			c.Abort()
+		}
+		return
+	}
+
+	body := cch.Body
+	contentEncoding := ""
+	if cch.Encoding != "" {
+		if acceptsEncoding(c.Request, cch.Encoding) {
+			contentEncoding = cch.Encoding
+		} else if decompressed, err := decompressBody(cch.Encoding, cch.Body); err == nil {
+			body = decompressed
 		}
 	}
+
+	//start := time.Now()
+	c.Writer.WriteHeader(cch.Status)
+	for k, val := range cch.Header {
+		if strings.EqualFold(k, "Content-Length") {
+			// the stored value may no longer match body, e.g. after
+			// decompressing for a client that didn't ask for it.
+			continue
+		}
+		for _, v := range val {
+			c.Writer.Header().Add(k, v)
+		}
+	}
+	if contentEncoding != "" {
+		c.Writer.Header().Set("Content-Encoding", contentEncoding)
+	}
+	c.Writer.Header().Set("Etag", `"`+cch.Hash+`"`)
+	c.Writer.Header().Set("Last-Modified", cch.CreatedAt.UTC().Format(http.TimeFormat))
+	c.Writer.Header().Set("X-Gin-Cache-Hit", hitLabel)
+	c.Writer.Header().Set("Cache-Control", cacheControl)
+	//c.Writer.Header().Set("Cache-Control", getCacheControl(getTimeDiffFromNow(cache.options.Expire).Nanoseconds() / 1e9))
+
+	//t := fmt.Sprintf("%f ms", timeNow.Sub(start).Seconds()*1000)
+	//c.Writer.Header().Add("X-Gin-Cache", t)
+
+	c.Writer.Write(body)
+	if !cache.options.DoNotUseAbort {
+		c.Abort()
+	}
 }
 
 
-func getCacheControl(maxAge int64) string {
+func getCacheControl(maxAge int64, staleWhileRevalidate int64) string {
 	if maxAge == 0 {
 		return "max-age=0, no-cache, no-store, must-revalidate"
 	}
 
-	return "max-age=" + strconv.FormatInt(maxAge, 10) + ", public"
+	cc := "max-age=" + strconv.FormatInt(maxAge, 10) + ", public"
+	if staleWhileRevalidate > 0 {
+		cc += ", stale-while-revalidate=" + strconv.FormatInt(staleWhileRevalidate, 10)
+	}
+	return cc
 }
 
 func getTimeDiff(t time.Time, defaultTime time.Duration) time.Duration {
@@ -231,6 +611,30 @@ func md5String(url string) string {
 	return hex.EncodeToString(h.Sum(nil))
 }
 
+func sha256String(body []byte) string {
+	sum := sha256.Sum256(body)
+	return hex.EncodeToString(sum[:])
+}
+
+// isNotModified implements conditional GET: it reports whether the request's
+// If-None-Match or If-Modified-Since headers indicate the client already has
+// the cached representation.
+func isNotModified(r *http.Request, cch *Cached) bool {
+	if inm := r.Header.Get("If-None-Match"); inm != "" {
+		return inm == `"`+cch.Hash+`"` || inm == cch.Hash || inm == "*"
+	}
+
+	if ims := r.Header.Get("If-Modified-Since"); ims != "" {
+		t, err := dateparse.ParseAny(ims)
+		if err != nil {
+			return false
+		}
+		return !cch.CreatedAt.After(t.Add(time.Second))
+	}
+
+	return false
+}
+
 func init() {
 	gob.Register(Cached{})
 }
@@ -0,0 +1,375 @@
+package cache
+
+import (
+	"bytes"
+	"encoding/gob"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// newTestRouter wires mw ahead of handler behind a middleware that stamps
+// "TimeNow" on the context, which newHandler relies on for expiry math.
+func newTestRouter(mw gin.HandlerFunc, handler gin.HandlerFunc) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(func(c *gin.Context) {
+		c.Set("TimeNow", time.Now())
+		c.Next()
+	})
+	r.Use(mw)
+	r.GET("/", handler)
+	return r
+}
+
+func TestBypassCache(t *testing.T) {
+	newReq := func(rawQuery string, cookies ...*http.Cookie) *gin.Context {
+		req := httptest.NewRequest(http.MethodGet, "/?"+rawQuery, nil)
+		for _, c := range cookies {
+			req.AddCookie(c)
+		}
+		c, _ := gin.CreateTestContext(httptest.NewRecorder())
+		c.Request = req
+		return c
+	}
+
+	cases := []struct {
+		name   string
+		c      *gin.Context
+		cfg    *RouteCacheConfig
+		bypass bool
+	}{
+		{"no bypass signal", newReq(""), &RouteCacheConfig{}, false},
+		{"cache=0", newReq("cache=0"), &RouteCacheConfig{}, true},
+		{"cache=false", newReq("cache=false"), &RouteCacheConfig{}, true},
+		{"cache=FALSE case-insensitive", newReq("cache=FALSE"), &RouteCacheConfig{}, true},
+		{"cache=1 does not bypass", newReq("cache=1"), &RouteCacheConfig{}, false},
+		{
+			"do-not-cache cookie present",
+			newReq("", &http.Cookie{Name: "session", Value: "abc"}),
+			&RouteCacheConfig{DoNotCacheCookies: []string{"session"}},
+			true,
+		},
+		{
+			"do-not-cache cookie absent",
+			newReq(""),
+			&RouteCacheConfig{DoNotCacheCookies: []string{"session"}},
+			false,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := bypassCache(tc.c, tc.cfg); got != tc.bypass {
+				t.Errorf("bypassCache() = %v, want %v", got, tc.bypass)
+			}
+		})
+	}
+}
+
+func TestCacheable(t *testing.T) {
+	cases := []struct {
+		name   string
+		status int
+		header http.Header
+		want   bool
+	}{
+		{"200 OK", http.StatusOK, http.Header{}, true},
+		{"404 not cacheable", http.StatusNotFound, http.Header{}, false},
+		{"500 not cacheable", http.StatusInternalServerError, http.Header{}, false},
+		{"no-store not cacheable", http.StatusOK, http.Header{"Cache-Control": []string{"no-store"}}, false},
+		{"no-cache is still cacheable", http.StatusOK, http.Header{"Cache-Control": []string{"no-cache"}}, true},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := cacheable(tc.status, tc.header); got != tc.want {
+				t.Errorf("cacheable(%d, %v) = %v, want %v", tc.status, tc.header, got, tc.want)
+			}
+		})
+	}
+}
+
+func TestBypassCacheViaQueryEndToEnd(t *testing.T) {
+	var calls int32
+	r := newTestRouter(New(Options{Store: NewInMemory(), Expire: time.Minute}), func(c *gin.Context) {
+		atomic.AddInt32(&calls, 1)
+		c.String(http.StatusOK, "body")
+	})
+
+	for i := 0; i < 3; i++ {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/?cache=0", nil))
+	}
+
+	if got := atomic.LoadInt32(&calls); got != 3 {
+		t.Fatalf("handler ran %d times with cache=0 on every request, want 3 (bypass must skip caching entirely)", got)
+	}
+}
+
+func TestNewWithConfigNilSkipsCaching(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(func(c *gin.Context) {
+		c.Set("TimeNow", time.Now())
+		c.Next()
+	})
+
+	var calls int32
+	r.Use(NewWithConfig(r, func(c *gin.Context) *RouteCacheConfig {
+		if c.Request.URL.Path == "/uncached" {
+			return nil
+		}
+		return &RouteCacheConfig{Expire: time.Minute}
+	}))
+	r.GET("/uncached", func(c *gin.Context) {
+		atomic.AddInt32(&calls, 1)
+		c.String(http.StatusOK, "body")
+	})
+
+	for i := 0; i < 3; i++ {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/uncached", nil))
+		if got := w.Header().Get("X-Gin-Cache-Hit"); got != "" {
+			t.Errorf("request %d: X-Gin-Cache-Hit = %q, want empty (route resolved to nil config)", i, got)
+		}
+	}
+
+	if got := atomic.LoadInt32(&calls); got != 3 {
+		t.Fatalf("handler ran %d times, want 3 (nil RouteCacheConfig must bypass caching every time)", got)
+	}
+}
+
+func TestRouteCacheConfigKeyOverride(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(func(c *gin.Context) {
+		c.Set("TimeNow", time.Now())
+		c.Next()
+	})
+
+	var calls int32
+	r.Use(NewWithConfig(r, func(c *gin.Context) *RouteCacheConfig {
+		return &RouteCacheConfig{Expire: time.Minute, Key: "fixed-key"}
+	}))
+	r.GET("/a", func(c *gin.Context) {
+		atomic.AddInt32(&calls, 1)
+		c.String(http.StatusOK, "body")
+	})
+	r.GET("/b", func(c *gin.Context) {
+		atomic.AddInt32(&calls, 1)
+		c.String(http.StatusOK, "body")
+	})
+
+	r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/a", nil))
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/b", nil))
+
+	if got := w.Header().Get("X-Gin-Cache-Hit"); got != "HIT" {
+		t.Fatalf("second route with the same overridden Key: X-Gin-Cache-Hit = %q, want HIT", got)
+	}
+	if got := atomic.LoadInt32(&calls); got != 1 {
+		t.Fatalf("handler ran %d times across two distinct routes sharing an overridden Key, want 1", got)
+	}
+}
+
+func TestConcurrentMissesCoalesceToOneHandlerRun(t *testing.T) {
+	var calls int32
+	r := newTestRouter(New(Options{Store: NewInMemory(), Expire: time.Minute}), func(c *gin.Context) {
+		atomic.AddInt32(&calls, 1)
+		time.Sleep(20 * time.Millisecond)
+		c.String(http.StatusOK, "body")
+	})
+
+	const n = 5
+	var wg sync.WaitGroup
+	codes := make([]int, n)
+	bodies := make([]string, n)
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			w := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			r.ServeHTTP(w, req)
+			codes[i] = w.Code
+			bodies[i] = w.Body.String()
+		}(i)
+	}
+	wg.Wait()
+
+	if got := atomic.LoadInt32(&calls); got != 1 {
+		t.Fatalf("handler ran %d times, want 1", got)
+	}
+
+	for i := 0; i < n; i++ {
+		if codes[i] != http.StatusOK {
+			t.Errorf("request %d: status = %d, want %d", i, codes[i], http.StatusOK)
+		}
+		if bodies[i] != "body" {
+			t.Errorf("request %d: body = %q, want %q (leader must not write its response twice)", i, bodies[i], "body")
+		}
+	}
+}
+
+func TestNewCachedCompressesBodyAboveMinCompressSize(t *testing.T) {
+	timeNow := time.Now()
+	header := http.Header{"Content-Type": []string{"text/plain"}}
+
+	small := newCached(http.StatusOK, []byte("short"), header, "hash", timeNow, &RouteCacheConfig{MinCompressSize: 10})
+	if small.Encoding != "" {
+		t.Errorf("body below MinCompressSize: Encoding = %q, want empty", small.Encoding)
+	}
+	if string(small.Body) != "short" {
+		t.Errorf("body below MinCompressSize: Body = %q, want unchanged", small.Body)
+	}
+
+	body := []byte("this body is long enough to clear the configured MinCompressSize threshold")
+	gzipped := newCached(http.StatusOK, body, header, "hash", timeNow, &RouteCacheConfig{MinCompressSize: 10})
+	if gzipped.Encoding != "gzip" {
+		t.Fatalf("Encoding = %q, want gzip", gzipped.Encoding)
+	}
+	got, err := decompressBody("gzip", gzipped.Body)
+	if err != nil {
+		t.Fatalf("decompressBody: %v", err)
+	}
+	if string(got) != string(body) {
+		t.Errorf("decompressed body = %q, want %q", got, body)
+	}
+
+	br := newCached(http.StatusOK, body, header, "hash", timeNow, &RouteCacheConfig{MinCompressSize: 10, CompressionEncoding: "br"})
+	if br.Encoding != "br" {
+		t.Fatalf("Encoding = %q, want br", br.Encoding)
+	}
+
+	nonCompressible := http.Header{"Content-Type": []string{"image/png"}}
+	unCompressed := newCached(http.StatusOK, body, nonCompressible, "hash", timeNow, &RouteCacheConfig{MinCompressSize: 10})
+	if unCompressed.Encoding != "" {
+		t.Errorf("non-compressible Content-Type: Encoding = %q, want empty", unCompressed.Encoding)
+	}
+}
+
+// TestServeCachedDecompressesForClientsThatDontAcceptEncoding covers both
+// sides of serveCached's encoding negotiation. Accept-Encoding is one of the
+// default vary headers, so a request's Accept-Encoding value is baked into
+// its cache key; each case below reuses the exact same header on both the
+// priming (miss) and the asserted (hit) request so they land on one entry.
+func TestServeCachedDecompressesForClientsThatDontAcceptEncoding(t *testing.T) {
+	newRouter := func() *gin.Engine {
+		return newTestRouter(New(Options{Store: NewInMemory(), Expire: time.Minute, MinCompressSize: 1}), func(c *gin.Context) {
+			c.Data(http.StatusOK, "text/plain", []byte("hello compressed world"))
+		})
+	}
+
+	t.Run("accepts gzip", func(t *testing.T) {
+		r := newRouter()
+		newReq := func() *http.Request {
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.Header.Set("Accept-Encoding", "gzip")
+			return req
+		}
+		r.ServeHTTP(httptest.NewRecorder(), newReq()) // miss: populates the entry
+
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, newReq()) // hit: same key, same Accept-Encoding
+		if enc := w.Header().Get("Content-Encoding"); enc != "gzip" {
+			t.Fatalf("hit with Accept-Encoding: gzip: Content-Encoding = %q, want gzip", enc)
+		}
+	})
+
+	t.Run("does not accept gzip", func(t *testing.T) {
+		r := newRouter()
+		newReq := func() *http.Request {
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.Header.Set("Accept-Encoding", "deflate")
+			return req
+		}
+		r.ServeHTTP(httptest.NewRecorder(), newReq()) // miss: populates the entry (stored as gzip regardless)
+
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, newReq()) // hit: same key, client still can't take gzip
+		if enc := w.Header().Get("Content-Encoding"); enc != "" {
+			t.Errorf("hit with Accept-Encoding: deflate: Content-Encoding = %q, want empty", enc)
+		}
+		if w.Body.String() != "hello compressed world" {
+			t.Errorf("hit with Accept-Encoding: deflate: body = %q, want decompressed original", w.Body.String())
+		}
+	})
+}
+
+func TestStaleWhileRevalidateRefreshesCacheInBackground(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	store := NewInMemory()
+
+	r.Use(func(c *gin.Context) {
+		c.Set("TimeNow", time.Now())
+		c.Next()
+	})
+	// StaleWhileRevalidate > Expire clamps StaleAt to the entry's creation
+	// time, so the very next request always takes the stale branch
+	// regardless of how the bucketed ExpireAt rounding landed.
+	r.Use(New(Options{
+		Store:                store,
+		Expire:               time.Hour,
+		StaleWhileRevalidate: 2 * time.Hour,
+		Engine:               r,
+	}))
+
+	var calls int32
+	r.GET("/", func(c *gin.Context) {
+		Tag(c, "greeting")
+		n := atomic.AddInt32(&calls, 1)
+		c.String(http.StatusOK, fmt.Sprintf("v%d", n))
+	})
+
+	key := KEY_PREFIX + md5String("/")
+
+	w1 := httptest.NewRecorder()
+	r.ServeHTTP(w1, httptest.NewRequest(http.MethodGet, "/", nil))
+	if w1.Body.String() != "v1" {
+		t.Fatalf("initial response body = %q, want v1", w1.Body.String())
+	}
+
+	w2 := httptest.NewRecorder()
+	r.ServeHTTP(w2, httptest.NewRequest(http.MethodGet, "/", nil))
+	if got := w2.Header().Get("X-Gin-Cache-Hit"); got != "STALE" {
+		t.Fatalf("second response X-Gin-Cache-Hit = %q, want STALE", got)
+	}
+	if w2.Body.String() != "v1" {
+		t.Fatalf("stale response body = %q, want v1 (the original cached content)", w2.Body.String())
+	}
+
+	// The refresh runs in a background goroutine spawned by the request
+	// above; poll the store directly for it to land.
+	deadline := time.Now().Add(2 * time.Second)
+	var cch *Cached
+	for time.Now().Before(deadline) {
+		data, err := store.Get(key)
+		if err == nil {
+			dec := gob.NewDecoder(bytes.NewReader(data))
+			if decErr := dec.Decode(&cch); decErr == nil && string(cch.Body) == "v2" {
+				break
+			}
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	if cch == nil || string(cch.Body) != "v2" {
+		got := "<nothing>"
+		if cch != nil {
+			got = string(cch.Body)
+		}
+		t.Fatalf("refreshed cache body = %q, want %q (non-empty, freshly fetched content)", got, "v2")
+	}
+	if want := []string{"greeting"}; !reflect.DeepEqual(cch.Tags, want) {
+		t.Errorf("refreshed cache tags = %v, want %v (tags from the stale entry should carry over)", cch.Tags, want)
+	}
+}
@@ -0,0 +1,81 @@
+package cache
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestIsCompressible(t *testing.T) {
+	cases := []struct {
+		contentType string
+		want        bool
+	}{
+		{"text/html; charset=utf-8", true},
+		{"application/json", true},
+		{"application/json; charset=utf-8", true},
+		{"application/javascript", true},
+		{"application/xml", true},
+		{"application/xhtml+xml", true},
+		{"image/svg+xml", true},
+		{"image/png", false},
+		{"application/octet-stream", false},
+		{"", false},
+	}
+
+	for _, tc := range cases {
+		if got := isCompressible(tc.contentType); got != tc.want {
+			t.Errorf("isCompressible(%q) = %v, want %v", tc.contentType, got, tc.want)
+		}
+	}
+}
+
+func TestCompressBodyRoundTrip(t *testing.T) {
+	for _, encoding := range []string{"gzip", "br"} {
+		t.Run(encoding, func(t *testing.T) {
+			body := []byte("the quick brown fox jumps over the lazy dog, repeated for good measure")
+
+			compressed, err := compressBody(encoding, body)
+			if err != nil {
+				t.Fatalf("compressBody: %v", err)
+			}
+			if len(compressed) == 0 {
+				t.Fatal("compressBody returned no bytes")
+			}
+
+			got, err := decompressBody(encoding, compressed)
+			if err != nil {
+				t.Fatalf("decompressBody: %v", err)
+			}
+			if string(got) != string(body) {
+				t.Errorf("decompressBody round-trip = %q, want %q", got, body)
+			}
+		})
+	}
+}
+
+func TestAcceptsEncoding(t *testing.T) {
+	req := httpRequestWithHeader("Accept-Encoding", "gzip, deflate, br")
+
+	if !acceptsEncoding(req, "") {
+		t.Error("acceptsEncoding with empty encoding = false, want true (uncompressed always accepted)")
+	}
+	if !acceptsEncoding(req, "gzip") {
+		t.Error("acceptsEncoding(gzip) = false, want true")
+	}
+	if !acceptsEncoding(req, "br") {
+		t.Error("acceptsEncoding(br) = false, want true")
+	}
+
+	noHeader := httpRequestWithHeader("", "")
+	if acceptsEncoding(noHeader, "gzip") {
+		t.Error("acceptsEncoding(gzip) with no Accept-Encoding header = true, want false")
+	}
+}
+
+func httpRequestWithHeader(key, value string) *http.Request {
+	req, _ := http.NewRequest(http.MethodGet, "/", nil)
+	if key != "" {
+		req.Header.Set(key, value)
+	}
+	return req
+}
@@ -0,0 +1,58 @@
+package cache
+
+import "sync"
+
+type inMemoryStore struct {
+	mu   sync.RWMutex
+	data map[string][]byte
+}
+
+// NewInMemory returns a Store backed by an unbounded in-memory map. It never
+// evicts entries on its own; expiry is handled by Cache.Get via ExpireAt.
+func NewInMemory() Store {
+	return &inMemoryStore{
+		data: make(map[string][]byte),
+	}
+}
+
+func (s *inMemoryStore) Get(key string) ([]byte, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	data, ok := s.data[key]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return data, nil
+}
+
+func (s *inMemoryStore) Set(key string, data []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.data[key] = data
+	return nil
+}
+
+func (s *inMemoryStore) Update(key string, data []byte) error {
+	return s.Set(key, data)
+}
+
+func (s *inMemoryStore) Remove(key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.data, key)
+	return nil
+}
+
+func (s *inMemoryStore) Keys() []string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	keys := make([]string, 0, len(s.data))
+	for k := range s.data {
+		keys = append(keys, k)
+	}
+	return keys
+}
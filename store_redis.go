@@ -0,0 +1,136 @@
+package cache
+
+import (
+	"context"
+	"strings"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// TaggedStore is implemented by Store backends that support grouping entries
+// under tags for bulk invalidation, such as the store returned by
+// NewRedisStore.
+type TaggedStore interface {
+	Store
+	SetWithTags(key string, data []byte, tags []string) error
+	InvalidateTag(tag string) error
+}
+
+// RedisOptions configures NewRedisStore.
+type RedisOptions struct {
+	// KeyPrefix namespaces both cache entries and tag sets. Defaults to
+	// KEY_PREFIX.
+	KeyPrefix string
+	// Context is used for every Redis command issued by the store. Defaults
+	// to context.Background().
+	Context context.Context
+}
+
+func (o *RedisOptions) init() {
+	if o.KeyPrefix == "" {
+		o.KeyPrefix = KEY_PREFIX
+	}
+	if o.Context == nil {
+		o.Context = context.Background()
+	}
+}
+
+type redisStore struct {
+	client *redis.Client
+	opts   RedisOptions
+}
+
+// NewRedisStore returns a Store backed by Redis so multiple gin instances
+// behind a load balancer can share a cache. The returned value also
+// implements TaggedStore.
+func NewRedisStore(client *redis.Client, opts RedisOptions) Store {
+	opts.init()
+	return &redisStore{client: client, opts: opts}
+}
+
+func (s *redisStore) tagKey(tag string) string {
+	return s.opts.KeyPrefix + "tag:" + tag
+}
+
+// entryKey rewrites a key built by cache.go, which always starts with the
+// package's KEY_PREFIX, onto this store's configured KeyPrefix, so a custom
+// KeyPrefix namespaces cache entries the same way it already namespaces tag
+// sets.
+func (s *redisStore) entryKey(key string) string {
+	if s.opts.KeyPrefix == KEY_PREFIX {
+		return key
+	}
+	return s.opts.KeyPrefix + strings.TrimPrefix(key, KEY_PREFIX)
+}
+
+func (s *redisStore) Get(key string) ([]byte, error) {
+	data, err := s.client.Get(s.opts.Context, s.entryKey(key)).Bytes()
+	if err == redis.Nil {
+		return nil, ErrNotFound
+	}
+	return data, err
+}
+
+func (s *redisStore) Set(key string, data []byte) error {
+	return s.client.Set(s.opts.Context, s.entryKey(key), data, 0).Err()
+}
+
+func (s *redisStore) Update(key string, data []byte) error {
+	return s.Set(key, data)
+}
+
+func (s *redisStore) Remove(key string) error {
+	return s.client.Del(s.opts.Context, s.entryKey(key)).Err()
+}
+
+// Keys returns every cache entry key under the configured prefix, excluding
+// the tag sets tagKey stores alongside them under the same prefix.
+func (s *redisStore) Keys() []string {
+	keys, err := s.client.Keys(s.opts.Context, s.opts.KeyPrefix+"*").Result()
+	if err != nil {
+		return nil
+	}
+
+	tagPrefix := s.opts.KeyPrefix + "tag:"
+	entries := keys[:0]
+	for _, k := range keys {
+		if strings.HasPrefix(k, tagPrefix) {
+			continue
+		}
+		entries = append(entries, k)
+	}
+	return entries
+}
+
+// SetWithTags stores data under key and records key as a member of each
+// tag's set so InvalidateTag can later find and remove it.
+func (s *redisStore) SetWithTags(key string, data []byte, tags []string) error {
+	if err := s.Set(key, data); err != nil {
+		return err
+	}
+
+	for _, tag := range tags {
+		if err := s.client.SAdd(s.opts.Context, s.tagKey(tag), s.entryKey(key)).Err(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// InvalidateTag removes every cache entry tagged with tag, then the tag set
+// itself.
+func (s *redisStore) InvalidateTag(tag string) error {
+	tagKey := s.tagKey(tag)
+
+	members, err := s.client.SMembers(s.opts.Context, tagKey).Result()
+	if err != nil {
+		return err
+	}
+	if len(members) > 0 {
+		if err := s.client.Del(s.opts.Context, members...).Err(); err != nil {
+			return err
+		}
+	}
+
+	return s.client.Del(s.opts.Context, tagKey).Err()
+}
@@ -0,0 +1,122 @@
+package cache
+
+import (
+	"sort"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+)
+
+// newTestRedisStore spins up an in-process miniredis instance so these tests
+// exercise the real redis.Client code paths without a live Redis server.
+func newTestRedisStore(t *testing.T, opts RedisOptions) *redisStore {
+	t.Helper()
+	mr, err := miniredis.Run()
+	if err != nil {
+		t.Fatalf("miniredis.Run: %v", err)
+	}
+	t.Cleanup(mr.Close)
+
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { client.Close() })
+
+	return NewRedisStore(client, opts).(*redisStore)
+}
+
+func TestRedisStoreSetGetRemoveRoundTrip(t *testing.T) {
+	s := newTestRedisStore(t, RedisOptions{})
+
+	key := KEY_PREFIX + "abc123"
+	if err := s.Set(key, []byte("hello")); err != nil {
+		t.Fatalf("Set: %v", err)
+	}
+
+	got, err := s.Get(key)
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	if string(got) != "hello" {
+		t.Fatalf("Get = %q, want %q", got, "hello")
+	}
+
+	if err := s.Remove(key); err != nil {
+		t.Fatalf("Remove: %v", err)
+	}
+	if _, err := s.Get(key); err != ErrNotFound {
+		t.Fatalf("Get after Remove = %v, want ErrNotFound", err)
+	}
+}
+
+func TestRedisStoreSetWithTagsAndInvalidateTag(t *testing.T) {
+	s := newTestRedisStore(t, RedisOptions{})
+
+	k1, k2 := KEY_PREFIX+"post-1", KEY_PREFIX+"post-2"
+	if err := s.SetWithTags(k1, []byte("one"), []string{"posts"}); err != nil {
+		t.Fatalf("SetWithTags(%q): %v", k1, err)
+	}
+	if err := s.SetWithTags(k2, []byte("two"), []string{"posts", "post-2"}); err != nil {
+		t.Fatalf("SetWithTags(%q): %v", k2, err)
+	}
+
+	if err := s.InvalidateTag("posts"); err != nil {
+		t.Fatalf("InvalidateTag: %v", err)
+	}
+
+	if _, err := s.Get(k1); err != ErrNotFound {
+		t.Fatalf("Get(%q) after InvalidateTag = %v, want ErrNotFound", k1, err)
+	}
+	if _, err := s.Get(k2); err != ErrNotFound {
+		t.Fatalf("Get(%q) after InvalidateTag = %v, want ErrNotFound", k2, err)
+	}
+}
+
+func TestRedisStoreKeysExcludesTagSets(t *testing.T) {
+	s := newTestRedisStore(t, RedisOptions{})
+
+	k1, k2 := KEY_PREFIX+"post-1", KEY_PREFIX+"post-2"
+	if err := s.SetWithTags(k1, []byte("one"), []string{"posts"}); err != nil {
+		t.Fatalf("SetWithTags(%q): %v", k1, err)
+	}
+	if err := s.SetWithTags(k2, []byte("two"), []string{"posts"}); err != nil {
+		t.Fatalf("SetWithTags(%q): %v", k2, err)
+	}
+
+	got := s.Keys()
+	sort.Strings(got)
+	want := []string{k1, k2}
+	sort.Strings(want)
+
+	if len(got) != len(want) {
+		t.Fatalf("Keys() = %v, want %v (tag sets must not appear)", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("Keys() = %v, want %v (tag sets must not appear)", got, want)
+		}
+	}
+}
+
+func TestRedisStoreEntryKeyAppliesCustomPrefix(t *testing.T) {
+	s := &redisStore{opts: RedisOptions{KeyPrefix: "myapp:"}}
+
+	key := KEY_PREFIX + "abc123"
+	got := s.entryKey(key)
+	want := "myapp:abc123"
+	if got != want {
+		t.Errorf("entryKey(%q) = %q, want %q", key, got, want)
+	}
+
+	if got := s.tagKey("posts"); got != "myapp:tag:posts" {
+		t.Errorf("tagKey(%q) = %q, want %q", "posts", got, "myapp:tag:posts")
+	}
+}
+
+func TestRedisStoreEntryKeyDefaultPrefixIsNoOp(t *testing.T) {
+	s := &redisStore{opts: RedisOptions{KeyPrefix: KEY_PREFIX}}
+
+	key := KEY_PREFIX + "abc123"
+	if got := s.entryKey(key); got != key {
+		t.Errorf("entryKey(%q) = %q, want %q (unchanged)", key, got, key)
+	}
+}
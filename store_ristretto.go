@@ -0,0 +1,187 @@
+package cache
+
+import (
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/dgraph-io/ristretto"
+)
+
+// metricsReportInterval controls how often Options.MetricsHook is invoked
+// when the configured Store exposes ristretto metrics.
+const metricsReportInterval = 15 * time.Second
+
+// RistrettoConfig configures NewRistrettoStore. Zero values fall back to
+// sane defaults for a moderate-traffic cache.
+type RistrettoConfig struct {
+	// MaxCost is the maximum total cost, in bytes, the store may hold before
+	// evicting entries. Defaults to 20 MB.
+	MaxCost int64
+	// NumCounters is the number of keys to track access frequency for. As a
+	// rule of thumb it should be ~10x the number of items expected to fit in
+	// the cache. Defaults to 1e7.
+	NumCounters int64
+	// BufferItems is the size of ristretto's per-Get buffer. Defaults to 64,
+	// the value ristretto itself recommends.
+	BufferItems int64
+}
+
+func (cfg *RistrettoConfig) init() {
+	if cfg.MaxCost == 0 {
+		cfg.MaxCost = 20 << 20 // 20 MB
+	}
+	if cfg.NumCounters == 0 {
+		cfg.NumCounters = 1e7
+	}
+	if cfg.BufferItems == 0 {
+		cfg.BufferItems = 64
+	}
+}
+
+// RistrettoMetrics is a snapshot of a Ristretto-backed Store's runtime
+// counters, handed to Options.MetricsHook.
+type RistrettoMetrics struct {
+	Hits        uint64
+	Misses      uint64
+	Ratio       float64
+	CostAdded   uint64
+	CostEvicted uint64
+}
+
+// metricsProvider is implemented by stores that can report RistrettoMetrics.
+// New checks for it before wiring up Options.MetricsHook.
+type metricsProvider interface {
+	Metrics() RistrettoMetrics
+}
+
+// CostedStore is implemented by Store backends that want Cache.Set to pass
+// through the byte cost of an entry instead of just its encoded size.
+type CostedStore interface {
+	Store
+	SetWithCost(key string, data []byte, cost int64) error
+}
+
+type ristrettoStore struct {
+	cache *ristretto.Cache
+
+	// ristretto has no key enumeration API, so Keys() is served from this
+	// best-effort set, cleaned up lazily on Get misses.
+	mu   sync.RWMutex
+	keys map[string]struct{}
+}
+
+// NewRistrettoStore returns a Store backed by github.com/dgraph-io/ristretto,
+// evicting by byte cost rather than item count so MaxCost is enforced in
+// actual memory rather than entry count.
+func NewRistrettoStore(cfg RistrettoConfig) Store {
+	cfg.init()
+
+	rc, err := ristretto.NewCache(&ristretto.Config{
+		NumCounters: cfg.NumCounters,
+		MaxCost:     cfg.MaxCost,
+		BufferItems: cfg.BufferItems,
+		Metrics:     true,
+	})
+	panicIf(err)
+
+	return &ristrettoStore{
+		cache: rc,
+		keys:  make(map[string]struct{}),
+	}
+}
+
+func (s *ristrettoStore) Get(key string) ([]byte, error) {
+	v, ok := s.cache.Get(key)
+	if !ok {
+		s.mu.Lock()
+		delete(s.keys, key)
+		s.mu.Unlock()
+		return nil, ErrNotFound
+	}
+	return v.([]byte), nil
+}
+
+func (s *ristrettoStore) Set(key string, data []byte) error {
+	return s.SetWithCost(key, data, int64(len(data)))
+}
+
+// SetWithCost stores data under key with an explicit byte cost. Cache.Set
+// calls this with the sum of the cached body and header sizes when the
+// configured Store implements CostedStore.
+//
+// Set returning false means ristretto's admission policy dropped the item
+// under cost/contention pressure, not that key already exists — a routine
+// occurrence for a best-effort cache, so it's reported as a miss rather than
+// ErrAlreadyExists. Wait() is deliberately not called: it blocks until
+// ristretto's internal set-buffer goroutine has processed the write, which
+// is meant for making ristretto's own tests deterministic, not for a
+// production request path.
+func (s *ristrettoStore) SetWithCost(key string, data []byte, cost int64) error {
+	if !s.cache.Set(key, data, cost) {
+		return nil
+	}
+
+	s.mu.Lock()
+	s.keys[key] = struct{}{}
+	s.mu.Unlock()
+	return nil
+}
+
+func (s *ristrettoStore) Update(key string, data []byte) error {
+	return s.Set(key, data)
+}
+
+func (s *ristrettoStore) Remove(key string) error {
+	s.cache.Del(key)
+
+	s.mu.Lock()
+	delete(s.keys, key)
+	s.mu.Unlock()
+	return nil
+}
+
+func (s *ristrettoStore) Keys() []string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	keys := make([]string, 0, len(s.keys))
+	for k := range s.keys {
+		keys = append(keys, k)
+	}
+	return keys
+}
+
+func (s *ristrettoStore) Metrics() RistrettoMetrics {
+	m := s.cache.Metrics
+	return RistrettoMetrics{
+		Hits:        m.Hits(),
+		Misses:      m.Misses(),
+		Ratio:       m.Ratio(),
+		CostAdded:   m.CostAdded(),
+		CostEvicted: m.CostEvicted(),
+	}
+}
+
+// headerCost approximates the serialized size of an http.Header by summing
+// the byte length of every name/value pair it carries.
+func headerCost(h http.Header) int64 {
+	var cost int64
+	for name, values := range h {
+		for _, v := range values {
+			cost += int64(len(name)) + int64(len(v))
+		}
+	}
+	return cost
+}
+
+// reportMetrics polls a metricsProvider on an interval and invokes hook with
+// each snapshot until the process exits.
+func reportMetrics(mp metricsProvider, hook func(RistrettoMetrics)) {
+	ticker := time.NewTicker(metricsReportInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		hook(mp.Metrics())
+	}
+}
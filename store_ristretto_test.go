@@ -0,0 +1,104 @@
+package cache
+
+import (
+	"testing"
+	"time"
+)
+
+// pollRistretto retries get until it returns true or the deadline passes,
+// since SetWithCost no longer calls ristretto's Wait() and writes are applied
+// asynchronously by ristretto's internal buffer goroutine.
+func pollRistretto(t *testing.T, get func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		if get() {
+			return
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	t.Fatal("timed out waiting for ristretto write to land")
+}
+
+func TestRistrettoStoreSetGetRoundTrip(t *testing.T) {
+	s := NewRistrettoStore(RistrettoConfig{})
+
+	if err := s.Set("a", []byte("hello")); err != nil {
+		t.Fatalf("Set: %v", err)
+	}
+
+	pollRistretto(t, func() bool {
+		v, err := s.Get("a")
+		return err == nil && string(v) == "hello"
+	})
+}
+
+func TestRistrettoStoreRemoveDropsFromKeys(t *testing.T) {
+	s := NewRistrettoStore(RistrettoConfig{})
+
+	if err := s.Set("a", []byte("hello")); err != nil {
+		t.Fatalf("Set: %v", err)
+	}
+	pollRistretto(t, func() bool {
+		for _, k := range s.Keys() {
+			if k == "a" {
+				return true
+			}
+		}
+		return false
+	})
+
+	if err := s.Remove("a"); err != nil {
+		t.Fatalf("Remove: %v", err)
+	}
+	for _, k := range s.Keys() {
+		if k == "a" {
+			t.Fatalf("Keys() still contains %q after Remove", k)
+		}
+	}
+	if _, err := s.Get("a"); err != ErrNotFound {
+		t.Fatalf("Get after Remove: err = %v, want ErrNotFound", err)
+	}
+}
+
+// TestRistrettoStoreSetWithCostRejectionIsNotAnError confirms SetWithCost no
+// longer reports a rejected write (dropped by ristretto's admission policy
+// under cost pressure) as ErrAlreadyExists: that sentinel names a key that
+// already exists, which a dropped item is not, and a best-effort cache
+// dropping an item is routine, not exceptional.
+func TestRistrettoStoreSetWithCostRejectionIsNotAnError(t *testing.T) {
+	cs := NewRistrettoStore(RistrettoConfig{MaxCost: 1, NumCounters: 100}).(CostedStore)
+
+	err := cs.SetWithCost("too-big", []byte("this item costs far more than the configured MaxCost allows"), 1<<20)
+	if err != nil {
+		t.Fatalf("SetWithCost on a rejected write = %v, want nil", err)
+	}
+}
+
+func TestRistrettoStoreMetricsReportsHitsAndMisses(t *testing.T) {
+	store := NewRistrettoStore(RistrettoConfig{})
+	s, ok := store.(metricsProvider)
+	if !ok {
+		t.Fatal("NewRistrettoStore's return value does not implement metricsProvider")
+	}
+
+	if err := store.Set("a", []byte("hello")); err != nil {
+		t.Fatalf("Set: %v", err)
+	}
+	pollRistretto(t, func() bool {
+		_, err := store.Get("a")
+		return err == nil
+	})
+
+	if _, err := store.Get("missing"); err != ErrNotFound {
+		t.Fatalf("Get(missing) = %v, want ErrNotFound", err)
+	}
+
+	m := s.Metrics()
+	if m.Hits == 0 {
+		t.Errorf("Metrics().Hits = %d, want > 0", m.Hits)
+	}
+	if m.Misses == 0 {
+		t.Errorf("Metrics().Misses = %d, want > 0", m.Misses)
+	}
+}